@@ -0,0 +1,9 @@
+package config
+
+// AccessLog configures the HTTP access log emitted by the server.
+type AccessLog struct {
+	// Format selects the access log line format: "common" (Apache
+	// Common Log Format), "combined" (CLF plus referrer/user-agent) or
+	// "json" (structured, one object per request).
+	Format string `mapstructure:"format"`
+}
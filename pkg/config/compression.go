@@ -0,0 +1,19 @@
+package config
+
+// Compression configures the response content-encoding middleware that
+// replaced the previous gzip-only wrapper. Levels follow each library's
+// own scale (e.g. 1-11 for brotli, 1-22 for zstd, 1-9 for gzip); a level
+// left unset (0) falls back to that library's recommended default rather
+// than silently disabling compression.
+type Compression struct {
+	// Enabled turns on response compression. It defaults to false so that
+	// upgrading doesn't change response encoding for existing deployments
+	// without an explicit opt-in.
+	Enabled bool `mapstructure:"enabled"`
+
+	MinSize int `mapstructure:"min-size"`
+
+	BrotliLevel int `mapstructure:"brotli-level"`
+	ZstdLevel   int `mapstructure:"zstd-level"`
+	GzipLevel   int `mapstructure:"gzip-level"`
+}
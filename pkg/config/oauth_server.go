@@ -0,0 +1,22 @@
+package config
+
+// OAuthServer configures Pyroscope to act as an OAuth2/OIDC authorization
+// server in its own right, issuing scoped access tokens to third-party
+// applications (the Grafana plugin, the CLI, IDE integrations) so they
+// don't need a long-lived API key.
+type OAuthServer struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// AccessTokenTTLMinutes bounds how long a minted access token is
+	// valid for before the client must use its refresh token.
+	AccessTokenTTLMinutes int `mapstructure:"access-token-ttl-minutes"`
+
+	// SigningKeyFile is where the RS256 key pair used to sign access
+	// tokens is persisted, in PEM-encoded PKCS#1 form. It's generated on
+	// first start and reused on every subsequent one, so that restarting
+	// the server (or running several replicas off a shared file) doesn't
+	// invalidate tokens already handed out or change what's published at
+	// /.well-known/jwks.json. When empty, a fresh key is generated in
+	// memory on every start and never persisted.
+	SigningKeyFile string `mapstructure:"signing-key-file"`
+}
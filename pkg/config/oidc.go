@@ -0,0 +1,26 @@
+package config
+
+// OIDC configures authentication against a generic OpenID Connect
+// provider (Keycloak, Okta, Auth0, Dex, ...), discovered from its
+// .well-known/openid-configuration document.
+type OIDC struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client-id"`
+	ClientSecret string   `mapstructure:"client-secret"`
+	Scopes       []string `mapstructure:"scopes"`
+
+	// AllowedGroups restricts login to users who are members of at least
+	// one of these groups/claim values. Empty means no restriction.
+	AllowedGroups []string `mapstructure:"allowed-groups"`
+
+	// RoleMappings maps an ID-token group/claim value to the role the
+	// user should be granted. The first match wins; users that don't
+	// match any mapping get the default signup role.
+	RoleMappings map[string]string `mapstructure:"role-mappings"`
+
+	// AllowSignup creates a new user on first successful login when one
+	// doesn't already exist for the verified email.
+	AllowSignup bool `mapstructure:"allow-signup"`
+}
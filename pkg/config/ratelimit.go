@@ -0,0 +1,38 @@
+package config
+
+// RateLimit configures the rate-limiting middleware applied to the
+// ingestion endpoint and the query endpoints (/render, /labels, /export,
+// /api/exemplars:*). Limits are enforced per key (source IP, API key/user,
+// or application name, depending on the rule) using a token-bucket
+// algorithm: RPS is the sustained rate and Burst is the maximum number of
+// requests that may be let through instantly.
+type RateLimit struct {
+	Ingestion RateLimitRule `mapstructure:"ingestion"`
+	Query     RateLimitRule `mapstructure:"query"`
+}
+
+// RateLimitRule describes a single token-bucket rate limit and how the
+// limiter key is derived from the request.
+type RateLimitRule struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// RPS is the sustained number of requests per second allowed per key.
+	RPS float64 `mapstructure:"rps"`
+	// Burst is the maximum number of requests a single key may make
+	// instantaneously before being throttled.
+	Burst int `mapstructure:"burst"`
+
+	// KeyBy selects what the limiter keys on: "ip", "user" or "app".
+	KeyBy string `mapstructure:"key-by"`
+
+	// TrustForwardedFor makes the limiter trust the left-most address in
+	// X-Forwarded-For (set only behind a trusted reverse proxy).
+	TrustForwardedFor bool `mapstructure:"trust-forwarded-for"`
+
+	// CacheSize bounds the number of distinct keys tracked at once; the
+	// least recently used entries are evicted once the limit is reached.
+	CacheSize int `mapstructure:"cache-size"`
+	// CacheTTL is how long an idle key's bucket is kept before it is
+	// swept, so one-off clients don't leak memory forever.
+	CacheTTL int `mapstructure:"cache-ttl-seconds"`
+}
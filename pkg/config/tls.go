@@ -0,0 +1,42 @@
+package config
+
+// TLS configures how the server terminates TLS: either from a static
+// certificate/key pair (TLSCertificateFile/TLSKeyFile) or, when ACME is
+// enabled, automatically via Let's Encrypt.
+type TLS struct {
+	ACME ACME `mapstructure:"acme"`
+}
+
+// ACME configures automatic certificate issuance/renewal via an ACME
+// provider (Let's Encrypt by default).
+type ACME struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// HostWhitelist restricts which SANs the server will ever request a
+	// certificate for, required so a malicious client can't make the
+	// server burn through Let's Encrypt's rate limit for arbitrary
+	// hostnames.
+	HostWhitelist []string `mapstructure:"host-whitelist"`
+
+	// CacheDir is where issued certificates/keys/account data are
+	// persisted between restarts.
+	CacheDir string `mapstructure:"cache-dir"`
+
+	// Challenge selects the ACME challenge type. Only "http-01" (the
+	// default, requires HTTPChallengeAddr to be reachable on :80) is
+	// currently supported; dns-01 would need a provider-specific DNS
+	// client this server doesn't have yet, and setting anything else is
+	// rejected as a configuration error rather than silently falling back.
+	Challenge string `mapstructure:"challenge"`
+
+	// HTTPChallengeAddr is the companion listener address that answers
+	// ACME HTTP-01 challenges and redirects all other traffic to HTTPS.
+	HTTPChallengeAddr string `mapstructure:"http-challenge-addr"`
+
+	// Email is passed to the ACME provider for expiry/problem notices.
+	Email string `mapstructure:"email"`
+
+	// DirectoryURL overrides the ACME directory endpoint, e.g. to point
+	// at Let's Encrypt's staging environment.
+	DirectoryURL string `mapstructure:"directory-url"`
+}
@@ -0,0 +1,25 @@
+package config
+
+// Tracing configures the OpenTelemetry integration: where spans are
+// exported to and how aggressively they're sampled.
+type Tracing struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// Exporter selects the span exporter: "otlp-grpc", "otlp-http",
+	// "jaeger" or "none" (spans are created but never exported, useful
+	// for exercising the instrumentation without a collector).
+	Exporter string `mapstructure:"exporter"`
+	// Endpoint is the collector address for the selected exporter.
+	Endpoint string `mapstructure:"endpoint"`
+
+	// Sampler selects the sampling strategy: "always", "never", "parent"
+	// (respect the parent span's sampling decision) or "traceidratio".
+	Sampler string `mapstructure:"sampler"`
+	// SamplerArg is the sampling ratio used when Sampler is
+	// "traceidratio", between 0 and 1.
+	SamplerArg float64 `mapstructure:"sampler-arg"`
+
+	// ResourceAttributes are attached to every span emitted by this
+	// process, e.g. {"deployment.environment": "prod"}.
+	ResourceAttributes map[string]string `mapstructure:"resource-attributes"`
+}
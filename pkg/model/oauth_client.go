@@ -0,0 +1,94 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// OAuthClient is a third-party application registered to act as an OAuth2
+// client against Pyroscope's own authorization server (the Grafana
+// plugin, the CLI, IDE integrations, ...).
+type OAuthClient struct {
+	ID           uint   `gorm:"primarykey"`
+	ClientID     string `gorm:"uniqueIndex"`
+	ClientSecret string // stored hashed, as with API keys.
+	Name         string
+
+	RedirectURIs  StringArray
+	AllowedScopes StringArray
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// StringArray is a slice of strings stored in a single column as a JSON
+// array, via the Value/Scan pair below - gorm has no built-in support for
+// persisting a bare []string.
+type StringArray []string
+
+// Value implements driver.Valuer, encoding the slice as a JSON array.
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner, decoding a JSON array column back into a
+// StringArray.
+func (a *StringArray) Scan(src interface{}) error {
+	if src == nil {
+		*a = nil
+		return nil
+	}
+	var b []byte
+	switch v := src.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type %T for StringArray", src)
+	}
+	if len(b) == 0 {
+		*a = nil
+		return nil
+	}
+	return json.Unmarshal(b, a)
+}
+
+// OAuthScope is a single scope granted to an access token. Scopes are
+// either a Role (e.g. "admin", "agent") or an application-scoped
+// read/write grant (e.g. "profiles:read:myapp.cpu").
+type OAuthScope string
+
+const (
+	// ScopeOpenID is required for the OIDC-flavoured authorization code
+	// flow to return an ID token alongside the access token.
+	ScopeOpenID OAuthScope = "openid"
+)
+
+// ParseOAuthScopes splits a space-separated scope string, as received on
+// the /oauth/authorize and /oauth/token endpoints, into individual scopes.
+func ParseOAuthScopes(s string) []OAuthScope {
+	if s == "" {
+		return nil
+	}
+	var scopes []OAuthScope
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == ' ' {
+			if i > start {
+				scopes = append(scopes, OAuthScope(s[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return scopes
+}
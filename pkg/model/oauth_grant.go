@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// OAuthAuthorizationCode is a short-lived authorization code issued by
+// /oauth/authorize and redeemed once at /oauth/token, as part of the
+// authorization code + PKCE flow.
+type OAuthAuthorizationCode struct {
+	ID uint `gorm:"primarykey"`
+
+	Code        string `gorm:"uniqueIndex"`
+	ClientID    string
+	UserID      uint
+	RedirectURI string
+	Scopes      StringArray
+
+	// CodeChallenge/CodeChallengeMethod implement PKCE (RFC 7636); public
+	// clients (e.g. the CLI, IDE integrations) have no client secret, so
+	// the code_verifier presented at /oauth/token is what proves
+	// possession of the original request instead.
+	CodeChallenge       string
+	CodeChallengeMethod string
+
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// OAuthRefreshToken is a long-lived, revocable token that can be
+// exchanged for a new access token without involving the user again.
+type OAuthRefreshToken struct {
+	ID uint `gorm:"primarykey"`
+
+	Token    string `gorm:"uniqueIndex"`
+	ClientID string
+	UserID   uint
+	Scopes   StringArray
+
+	Revoked   bool
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,146 @@
+// Package acme wires the server's TLS listener into ACME/Let's Encrypt
+// for automatic certificate issuance and renewal, instead of requiring a
+// static TLSCertificateFile/TLSKeyFile pair.
+package acme
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+// Manager wraps an autocert.Manager with a logging, metrics-emitting and
+// self-signed fallback GetCertificate, so a rate-limited or
+// DNS-misconfigured ACME provider degrades gracefully instead of
+// crashing the server.
+type Manager struct {
+	autocert      *autocert.Manager
+	hostWhitelist autocert.HostPolicy
+	log           *logrus.Logger
+
+	certNotBefore *prometheus.GaugeVec
+	certNotAfter  *prometheus.GaugeVec
+
+	fallbackMu    sync.Mutex
+	fallbackCerts map[string]*tls.Certificate
+}
+
+// NewManager builds a Manager from cfg. DNS-01 challenges require a
+// provider-specific DNS client that isn't wired up yet; for now only
+// http-01 (the default) is supported, and an unsupported challenge type
+// is treated as a configuration error rather than silently falling back.
+func NewManager(cfg config.ACME, log *logrus.Logger, reg prometheus.Registerer) (*Manager, error) {
+	if cfg.Challenge != "" && cfg.Challenge != "http-01" {
+		return nil, fmt.Errorf("acme: challenge type %q is not supported yet, only http-01", cfg.Challenge)
+	}
+	if len(cfg.HostWhitelist) == 0 {
+		return nil, fmt.Errorf("acme: host-whitelist must not be empty")
+	}
+
+	client := &acme.Client{}
+	if cfg.DirectoryURL != "" {
+		client.DirectoryURL = cfg.DirectoryURL
+	}
+
+	hostWhitelist := autocert.HostWhitelist(cfg.HostWhitelist...)
+	am := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		HostPolicy: hostWhitelist,
+		Client:     client,
+		Email:      cfg.Email,
+	}
+
+	certNotBefore := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pyroscope",
+		Subsystem: "acme",
+		Name:      "cert_not_before_timestamp_seconds",
+		Help:      "Issuance time of the current ACME certificate, by SAN.",
+	}, []string{"host"})
+	certNotAfter := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pyroscope",
+		Subsystem: "acme",
+		Name:      "cert_not_after_timestamp_seconds",
+		Help:      "Expiry time of the current ACME certificate, by SAN, so operators can alert before it lapses.",
+	}, []string{"host"})
+	if reg != nil {
+		_ = reg.Register(certNotBefore)
+		_ = reg.Register(certNotAfter)
+	}
+
+	return &Manager{
+		autocert:      am,
+		hostWhitelist: hostWhitelist,
+		log:           log,
+		certNotBefore: certNotBefore,
+		certNotAfter:  certNotAfter,
+		fallbackCerts: make(map[string]*tls.Certificate),
+	}, nil
+}
+
+// TLSConfig returns a *tls.Config whose GetCertificate obtains (and
+// renews) certificates via ACME, falling back to a self-signed
+// certificate - rather than failing the handshake - when the ACME
+// provider can't currently issue one for an otherwise-allowed host
+// (rate-limited, DNS misconfigured). Hosts outside HostWhitelist are
+// always refused, same as without the fallback.
+func (m *Manager) TLSConfig() *tls.Config {
+	cfg := m.autocert.TLSConfig()
+	getCertificate := cfg.GetCertificate
+	cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		if err != nil {
+			if policyErr := m.hostWhitelist(hello.Context(), hello.ServerName); policyErr != nil {
+				return nil, err
+			}
+			m.log.WithError(err).WithField("server_name", hello.ServerName).
+				Error("acme: failed to obtain certificate, falling back to self-signed")
+			return m.fallbackCertificate(hello.ServerName)
+		}
+		m.recordExpiry(hello.ServerName, cert)
+		return cert, nil
+	}
+	return cfg
+}
+
+// fallbackCertificate returns a cached self-signed certificate for host,
+// generating one only once per host for as long as it remains valid
+// instead of paying the keygen/signing cost on every handshake that
+// falls back.
+func (m *Manager) fallbackCertificate(host string) (*tls.Certificate, error) {
+	m.fallbackMu.Lock()
+	defer m.fallbackMu.Unlock()
+
+	if cert, ok := m.fallbackCerts[host]; ok && cert.Leaf != nil && time.Now().Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+	cert, err := selfSignedCertificate(host)
+	if err != nil {
+		return nil, err
+	}
+	m.fallbackCerts[host] = cert
+	return cert, nil
+}
+
+// HTTPHandler returns the companion :80 handler that answers ACME
+// HTTP-01 challenges and redirects everything else to HTTPS.
+func (m *Manager) HTTPHandler() http.Handler {
+	return m.autocert.HTTPHandler(nil)
+}
+
+func (m *Manager) recordExpiry(host string, cert *tls.Certificate) {
+	if cert.Leaf == nil || host == "" {
+		return
+	}
+	m.certNotBefore.WithLabelValues(host).Set(float64(cert.Leaf.NotBefore.Unix()))
+	m.certNotAfter.WithLabelValues(host).Set(float64(cert.Leaf.NotAfter.Unix()))
+}
@@ -0,0 +1,72 @@
+package acme
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m, err := NewManager(config.ACME{
+		HostWhitelist: []string{"example.org"},
+		CacheDir:      t.TempDir(),
+	}, logrus.StandardLogger(), nil)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	return m
+}
+
+func TestFallbackCertificateIsCached(t *testing.T) {
+	m := newTestManager(t)
+
+	first, err := m.fallbackCertificate("example.org")
+	if err != nil {
+		t.Fatalf("fallbackCertificate() error = %v", err)
+	}
+	second, err := m.fallbackCertificate("example.org")
+	if err != nil {
+		t.Fatalf("fallbackCertificate() error = %v", err)
+	}
+	if first != second {
+		t.Error("expected a cached certificate to be reused instead of regenerated")
+	}
+}
+
+func TestFallbackCertificateRegeneratesOnceExpired(t *testing.T) {
+	m := newTestManager(t)
+
+	expired, err := m.fallbackCertificate("example.org")
+	if err != nil {
+		t.Fatalf("fallbackCertificate() error = %v", err)
+	}
+	expired.Leaf.NotAfter = time.Now().Add(-time.Minute)
+
+	fresh, err := m.fallbackCertificate("example.org")
+	if err != nil {
+		t.Fatalf("fallbackCertificate() error = %v", err)
+	}
+	if fresh == expired {
+		t.Error("expected an expired fallback certificate to be regenerated")
+	}
+}
+
+func TestFallbackCertificateIsPerHost(t *testing.T) {
+	m := newTestManager(t)
+
+	a, err := m.fallbackCertificate("a.example.org")
+	if err != nil {
+		t.Fatalf("fallbackCertificate() error = %v", err)
+	}
+	b, err := m.fallbackCertificate("b.example.org")
+	if err != nil {
+		t.Fatalf("fallbackCertificate() error = %v", err)
+	}
+	if a == b {
+		t.Error("expected distinct hosts to get distinct fallback certificates")
+	}
+}
@@ -0,0 +1,39 @@
+package acme
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, err := selfSignedCertificate("example.org")
+	if err != nil {
+		t.Fatalf("selfSignedCertificate() error = %v", err)
+	}
+	if cert.Leaf == nil {
+		t.Fatal("expected Leaf to be populated")
+	}
+	if cert.Leaf.Subject.CommonName != "example.org" {
+		t.Errorf("CommonName = %q, want %q", cert.Leaf.Subject.CommonName, "example.org")
+	}
+	if len(cert.Leaf.DNSNames) != 1 || cert.Leaf.DNSNames[0] != "example.org" {
+		t.Errorf("DNSNames = %v, want [example.org]", cert.Leaf.DNSNames)
+	}
+	now := time.Now()
+	if !cert.Leaf.NotBefore.Before(now) {
+		t.Error("expected NotBefore to be in the past")
+	}
+	if !cert.Leaf.NotAfter.After(now) {
+		t.Error("expected NotAfter to be in the future")
+	}
+}
+
+func TestSelfSignedCertificateDefaultsCommonName(t *testing.T) {
+	cert, err := selfSignedCertificate("")
+	if err != nil {
+		t.Fatalf("selfSignedCertificate() error = %v", err)
+	}
+	if cert.Leaf.Subject.CommonName == "" {
+		t.Error("expected a default CommonName when none is given")
+	}
+}
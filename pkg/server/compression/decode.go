@@ -0,0 +1,41 @@
+package compression
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/andybalholm/brotli"
+	contentencoding "github.com/johejo/go-content-encoding"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DecodeRequest wraps contentencoding.Decode() to additionally accept
+// zstd- and brotli-encoded request bodies, since agents pushing large
+// profiles to /ingest benefit from zstd's compression ratio. gzip and
+// identity bodies are delegated to contentencoding.Decode() unchanged.
+func DecodeRequest() func(http.Handler) http.Handler {
+	decodeGzip := contentencoding.Decode()
+	return func(next http.Handler) http.Handler {
+		gzipHandler := decodeGzip(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Header.Get("Content-Encoding") {
+			case "zstd":
+				zr, err := zstd.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, "invalid zstd body: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				defer zr.Close()
+				r.Header.Del("Content-Encoding")
+				r.Body = io.NopCloser(zr)
+				next.ServeHTTP(w, r)
+			case "br":
+				r.Header.Del("Content-Encoding")
+				r.Body = io.NopCloser(brotli.NewReader(r.Body))
+				next.ServeHTTP(w, r)
+			default:
+				gzipHandler.ServeHTTP(w, r)
+			}
+		})
+	}
+}
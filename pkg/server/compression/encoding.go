@@ -0,0 +1,122 @@
+// Package compression implements HTTP content-encoding negotiation
+// between brotli, zstd and gzip, replacing a gzip-only wrapper so that
+// clients advertising a better algorithm via Accept-Encoding get it.
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Encoding identifies a supported content-coding.
+type Encoding string
+
+const (
+	Identity Encoding = "identity"
+	Gzip     Encoding = "gzip"
+	Brotli   Encoding = "br"
+	Zstd     Encoding = "zstd"
+)
+
+// preference is the tie-break order used when two codings have the same
+// client-supplied q-value: prefer the better compression ratio.
+var preference = map[Encoding]int{Brotli: 3, Zstd: 2, Gzip: 1, Identity: 0}
+
+// negotiate picks the best encoding the server supports out of the
+// client's Accept-Encoding header. supported must include Identity.
+func negotiate(acceptEncoding string, supported map[Encoding]bool) Encoding {
+	if acceptEncoding == "" {
+		return Identity
+	}
+
+	type candidate struct {
+		enc Encoding
+		q   float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, q := parseQValue(part)
+		enc := Encoding(name)
+		if enc == "*" {
+			for e := range supported {
+				candidates = append(candidates, candidate{e, q})
+			}
+			continue
+		}
+		if !supported[enc] {
+			continue
+		}
+		candidates = append(candidates, candidate{enc, q})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return preference[candidates[i].enc] > preference[candidates[j].enc]
+	})
+
+	for _, c := range candidates {
+		if c.q > 0 {
+			return c.enc
+		}
+	}
+	return Identity
+}
+
+func parseQValue(part string) (name string, q float64) {
+	q = 1
+	fields := strings.Split(part, ";")
+	name = strings.TrimSpace(fields[0])
+	for _, f := range fields[1:] {
+		f = strings.TrimSpace(f)
+		if strings.HasPrefix(f, "q=") {
+			if v, err := strconv.ParseFloat(strings.TrimPrefix(f, "q="), 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return name, q
+}
+
+// incompressibleContentTypes lists response content types that are
+// already compressed (or otherwise not worth compressing further), so we
+// skip spending CPU on them.
+var incompressibleContentTypes = map[string]bool{
+	"application/vnd.google.protobuf":      true,
+	"application/x-gzip":                   true,
+	"application/x-protobuf":               true,
+	"application/octet-stream":             true,
+	"application/vnd.pyroscope.profile+pb": true,
+}
+
+func isIncompressible(contentType string) bool {
+	if idx := strings.IndexByte(contentType, ';'); idx >= 0 {
+		contentType = contentType[:idx]
+	}
+	return incompressibleContentTypes[strings.TrimSpace(contentType)]
+}
+
+// newGzipWriter returns a constructor for gzip writers at level, which
+// must already be a valid gzip compression level (newPools validates
+// this before calling in). gzip.NewWriterLevel only ever errors on an
+// invalid level, so panicking here turns a silent nil-writer bug (every
+// later Reset/Write on it panicking on first use) into a clear one at
+// construction time instead.
+func newGzipWriter(level int) func(w io.Writer) io.WriteCloser {
+	return func(w io.Writer) io.WriteCloser {
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			panic(fmt.Errorf("compression: invalid gzip level %d: %w", level, err))
+		}
+		return gw
+	}
+}
@@ -0,0 +1,31 @@
+package compression
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	allSupported := map[Encoding]bool{Identity: true, Gzip: true, Brotli: true, Zstd: true}
+
+	cases := []struct {
+		name      string
+		accept    string
+		supported map[Encoding]bool
+		want      Encoding
+	}{
+		{"empty header means identity", "", allSupported, Identity},
+		{"single encoding", "gzip", allSupported, Gzip},
+		{"equal q-values break tie by compression ratio", "gzip, br, zstd", allSupported, Brotli},
+		{"higher q-value wins over preference order", "br;q=0.1, gzip;q=0.9", allSupported, Gzip},
+		{"wildcard expands to supported codings", "*;q=0.5", allSupported, Brotli},
+		{"unsupported codings are ignored", "br, zstd", map[Encoding]bool{Identity: true, Gzip: true}, Identity},
+		{"zero q-value rejects that coding", "br;q=0, gzip;q=0.5", allSupported, Gzip},
+		{"all zero q-values fall back to identity", "br;q=0, gzip;q=0", allSupported, Identity},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := negotiate(c.accept, c.supported); got != c.want {
+				t.Errorf("negotiate(%q) = %v, want %v", c.accept, got, c.want)
+			}
+		})
+	}
+}
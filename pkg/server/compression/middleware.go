@@ -0,0 +1,182 @@
+package compression
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+// Middleware negotiates and applies response compression, replacing the
+// previous gzip-only gzhttp wrapper.
+type Middleware struct {
+	minSize  int
+	pools    *pools
+	bytesIn  *prometheus.CounterVec
+	bytesOut *prometheus.CounterVec
+}
+
+// NewMiddleware builds a compression Middleware from c. reg may be nil.
+func NewMiddleware(c config.Compression, reg prometheus.Registerer) (*Middleware, error) {
+	minSize := c.MinSize
+	if minSize <= 0 {
+		minSize = 2000
+	}
+
+	pools, err := newPools(c.GzipLevel, c.BrotliLevel, c.ZstdLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesIn := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pyroscope",
+		Subsystem: "compression",
+		Name:      "bytes_in_total",
+		Help:      "Uncompressed response bytes seen by the compression middleware, by algorithm.",
+	}, []string{"algorithm"})
+	bytesOut := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pyroscope",
+		Subsystem: "compression",
+		Name:      "bytes_out_total",
+		Help:      "Compressed response bytes written by the compression middleware, by algorithm.",
+	}, []string{"algorithm"})
+	if reg != nil {
+		_ = reg.Register(bytesIn)
+		_ = reg.Register(bytesOut)
+	}
+
+	return &Middleware{
+		minSize:  minSize,
+		pools:    pools,
+		bytesIn:  bytesIn,
+		bytesOut: bytesOut,
+	}, nil
+}
+
+// supportedEncodings never changes at runtime so it's safe to share.
+var supportedEncodings = map[Encoding]bool{Brotli: true, Zstd: true, Gzip: true, Identity: true}
+
+// Wrap returns an http.Handler that compresses next's response body with
+// the best encoding the client and server agree on.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := negotiate(r.Header.Get("Accept-Encoding"), supportedEncodings)
+		if enc == Identity {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressingResponseWriter{ResponseWriter: w, mdw: m, encoding: enc, minSize: m.minSize}
+		next.ServeHTTP(cw, r)
+		cw.Close()
+	})
+}
+
+// compressingResponseWriter buffers up to minSize bytes before deciding
+// whether compression is worthwhile (small responses aren't worth the
+// CPU, and some content types are already compressed), mirroring the
+// MinSize behaviour of the gzhttp wrapper it replaces.
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	mdw *Middleware
+
+	encoding Encoding
+	minSize  int
+	pool     *encoderPool
+
+	wroteHeader bool
+	statusCode  int
+	buf         []byte
+
+	enc     io.WriteCloser
+	decided bool
+	skip    bool
+}
+
+func (w *compressingResponseWriter) WriteHeader(status int) {
+	w.statusCode = status
+	w.wroteHeader = true
+}
+
+func (w *compressingResponseWriter) Write(p []byte) (int, error) {
+	if w.skip {
+		return w.ResponseWriter.Write(p)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize && !isIncompressible(w.ResponseWriter.Header().Get("Content-Type")) {
+			return len(p), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	w.mdw.bytesIn.WithLabelValues(string(w.encoding)).Add(float64(len(p)))
+	return w.enc.Write(p)
+}
+
+// decide picks whether to actually compress (buffer big enough / not an
+// already-compressed content type) and flushes the buffered prefix.
+func (w *compressingResponseWriter) decide() error {
+	w.decided = true
+	if len(w.buf) < w.minSize || isIncompressible(w.ResponseWriter.Header().Get("Content-Type")) {
+		w.skip = true
+		w.flushHeader()
+		_, err := w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+		return err
+	}
+
+	w.pool = w.mdw.pools.forEncoding(w.encoding)
+	w.ResponseWriter.Header().Set("Content-Encoding", string(w.encoding))
+	w.ResponseWriter.Header().Del("Content-Length")
+	w.ResponseWriter.Header().Add("Vary", "Accept-Encoding")
+	w.flushHeader()
+
+	counted := &countingWriter{w: w.ResponseWriter, counter: w.mdw.bytesOut.WithLabelValues(string(w.encoding))}
+	w.enc = w.pool.get(counted)
+	w.mdw.bytesIn.WithLabelValues(string(w.encoding)).Add(float64(len(w.buf)))
+	_, err := w.enc.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *compressingResponseWriter) flushHeader() {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+}
+
+// Close finalizes compression for the response, flushing any buffered
+// bytes that never hit the MinSize threshold and releasing the encoder
+// back to its pool.
+func (w *compressingResponseWriter) Close() {
+	if !w.decided {
+		w.skip = true
+		w.flushHeader()
+		_, _ = w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+		return
+	}
+	if w.enc == nil {
+		return
+	}
+	_ = w.enc.Close()
+	if w.pool != nil {
+		w.pool.put(w.enc)
+	}
+}
+
+type countingWriter struct {
+	w       http.ResponseWriter
+	counter prometheus.Counter
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.counter.Add(float64(n))
+	return n, err
+}
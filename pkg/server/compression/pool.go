@@ -0,0 +1,105 @@
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoderPool recycles writers for one (algorithm, level) pair, since
+// brotli/zstd/gzip writers are relatively expensive to set up and
+// requests are handled concurrently.
+type encoderPool struct {
+	pool sync.Pool
+}
+
+func newEncoderPool(newWriter func(w io.Writer) io.WriteCloser) *encoderPool {
+	return &encoderPool{
+		pool: sync.Pool{
+			New: func() interface{} { return newWriter(io.Discard) },
+		},
+	}
+}
+
+type resetter interface {
+	Reset(w io.Writer)
+}
+
+func (p *encoderPool) get(w io.Writer) io.WriteCloser {
+	enc := p.pool.Get().(io.WriteCloser)
+	enc.(resetter).Reset(w)
+	return enc
+}
+
+func (p *encoderPool) put(enc io.WriteCloser) {
+	p.pool.Put(enc)
+}
+
+// pools holds one encoderPool per supported algorithm and compression
+// level, built once from config.Compression.
+type pools struct {
+	gzip   *encoderPool
+	brotli *encoderPool
+	zstd   *encoderPool
+}
+
+// Default levels used when the corresponding config.Compression field is
+// left at its Go zero value (0), which for gzip in particular means
+// NoCompression - a valid level, but not one anybody configuring this
+// middleware actually wants.
+const (
+	defaultGzipLevel   = gzip.DefaultCompression
+	defaultBrotliLevel = 6
+	defaultZstdLevel   = 3
+)
+
+func newPools(gzipLevel, brotliLevel, zstdLevel int) (*pools, error) {
+	if gzipLevel == 0 {
+		gzipLevel = defaultGzipLevel
+	}
+	if gzipLevel < gzip.HuffmanOnly || gzipLevel > gzip.BestCompression {
+		return nil, fmt.Errorf("gzip-level must be between %d and %d, got %d", gzip.HuffmanOnly, gzip.BestCompression, gzipLevel)
+	}
+
+	if brotliLevel == 0 {
+		brotliLevel = defaultBrotliLevel
+	}
+	if brotliLevel < 0 || brotliLevel > 11 {
+		return nil, fmt.Errorf("brotli-level must be between 0 and 11, got %d", brotliLevel)
+	}
+
+	if zstdLevel == 0 {
+		zstdLevel = defaultZstdLevel
+	}
+	if zstdLevel < 1 || zstdLevel > 22 {
+		return nil, fmt.Errorf("zstd-level must be between 1 and 22, got %d", zstdLevel)
+	}
+
+	return &pools{
+		gzip: newEncoderPool(newGzipWriter(gzipLevel)),
+		brotli: newEncoderPool(func(w io.Writer) io.WriteCloser {
+			return brotli.NewWriterLevel(w, brotliLevel)
+		}),
+		zstd: newEncoderPool(func(w io.Writer) io.WriteCloser {
+			enc, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(zstdLevel)))
+			return enc
+		}),
+	}, nil
+}
+
+func (p *pools) forEncoding(enc Encoding) *encoderPool {
+	switch enc {
+	case Gzip:
+		return p.gzip
+	case Brotli:
+		return p.brotli
+	case Zstd:
+		return p.zstd
+	default:
+		return nil
+	}
+}
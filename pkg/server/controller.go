@@ -1,10 +1,10 @@
 package server
 
 import (
-	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	golog "log"
 	"net/http"
 	"net/http/pprof"
@@ -17,8 +17,6 @@ import (
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	contentencoding "github.com/johejo/go-content-encoding"
-	"github.com/klauspost/compress/gzhttp"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/pyroscope-io/pyroscope/pkg/history"
@@ -27,6 +25,7 @@ import (
 	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
 	"github.com/slok/go-http-metrics/middleware"
 	"github.com/slok/go-http-metrics/middleware/std"
+	"golang.org/x/oauth2"
 	"gorm.io/gorm"
 
 	"github.com/pyroscope-io/pyroscope/pkg/api"
@@ -36,7 +35,12 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/model"
 	"github.com/pyroscope-io/pyroscope/pkg/scrape"
 	"github.com/pyroscope-io/pyroscope/pkg/scrape/labels"
+	"github.com/pyroscope-io/pyroscope/pkg/server/acme"
+	"github.com/pyroscope-io/pyroscope/pkg/server/compression"
+	"github.com/pyroscope-io/pyroscope/pkg/server/correlation"
 	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
+	"github.com/pyroscope-io/pyroscope/pkg/server/ratelimit"
+	"github.com/pyroscope-io/pyroscope/pkg/server/tracing"
 	"github.com/pyroscope-io/pyroscope/pkg/service"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/util/hyperloglog"
@@ -47,8 +51,7 @@ import (
 //revive:disable:max-public-structs TODO: we will refactor this later
 
 const (
-	stateCookieName            = "pyroscopeState"
-	gzHTTPCompressionThreshold = 2000
+	stateCookieName = "pyroscopeState"
 )
 
 type Controller struct {
@@ -83,6 +86,17 @@ type Controller struct {
 
 	scrapeManager *scrape.Manager
 	historyMgr    history.Manager
+
+	ingestionRateLimiter *ratelimit.Middleware
+	queryRateLimiter     *ratelimit.Middleware
+
+	oauthTokenService  *service.OAuthTokenService
+	oauthClientService service.OAuthClientService
+
+	tracingMdw      *tracing.Middleware
+	tracingShutdown func(context.Context) error
+
+	acmeHTTPServer *http.Server
 }
 
 type Config struct {
@@ -166,9 +180,96 @@ func New(c Config) (*Controller, error) {
 		return nil, fmt.Errorf("default signup role is invalid: %w", err)
 	}
 
+	ctrl.ingestionRateLimiter = ctrl.newRateLimitMiddleware("ingest", ctrl.config.RateLimit.Ingestion, ratelimit.AppNameKeyFunc(ctrl.config.RateLimit.Ingestion.TrustForwardedFor))
+	ctrl.queryRateLimiter = ctrl.newRateLimitMiddleware("query", ctrl.config.RateLimit.Query, ratelimit.UserKeyFunc(ctrl.userKeyFromRequest))
+
+	tracerProvider, err := tracing.NewProvider(context.Background(), c.Configuration.Tracing, "pyroscope-server")
+	if err != nil {
+		return nil, fmt.Errorf("initializing tracing: %w", err)
+	}
+	ctrl.tracingMdw = tracing.NewMiddleware(c.MetricsRegisterer)
+	ctrl.tracingShutdown = tracerProvider.Shutdown
+
 	return &ctrl, nil
 }
 
+// CorrelationTransport wraps next (http.DefaultTransport when next is
+// nil) so that every request it sends carries the correlation ID of the
+// inbound request it was made on behalf of, stitching that request to
+// the outbound calls it triggers. Unlike assigning to
+// http.DefaultTransport, this doesn't affect unrelated outbound calls
+// elsewhere in the process. correlatedContext is the in-package call
+// site; see its doc comment for the one known gap.
+func CorrelationTransport(next http.RoundTripper) http.RoundTripper {
+	return correlation.RoundTripper{Next: next}
+}
+
+// correlatedContext returns r's context set up so that an
+// oauth2.Config.Exchange (or anything else that honors oauth2.HTTPClient)
+// called with it sends its outbound request through CorrelationTransport,
+// carrying r's correlation ID to the OIDC provider's token endpoint.
+//
+// TODO(kolesnikovae): the scrape manager and remote-read handler make
+// outbound calls of their own (scrape fetches, remote-read fan-out), but
+// both live outside this package/checkout, so CorrelationTransport isn't
+// wired into their http.Client yet. Until that lands, do not assume
+// correlation IDs propagate to scrape or remote-read calls - only to the
+// OIDC code exchange below.
+func (ctrl *Controller) correlatedContext(r *http.Request) context.Context {
+	client := &http.Client{Transport: CorrelationTransport(nil)}
+	return context.WithValue(r.Context(), oauth2.HTTPClient, client)
+}
+
+// newRateLimitMiddleware builds a rate-limit middleware for rule, or nil
+// when the rule is disabled. Admins always bypass the limiter.
+func (ctrl *Controller) newRateLimitMiddleware(route string, rule config.RateLimitRule, fallback ratelimit.KeyFunc) *ratelimit.Middleware {
+	if !rule.Enabled {
+		return nil
+	}
+
+	keyFunc := fallback
+	switch rule.KeyBy {
+	case "ip":
+		keyFunc = ratelimit.SourceIPKeyFunc(rule.TrustForwardedFor)
+	case "user":
+		keyFunc = ratelimit.UserKeyFunc(ctrl.userKeyFromRequest)
+	case "app":
+		keyFunc = ratelimit.AppNameKeyFunc(rule.TrustForwardedFor)
+	}
+
+	limiter := ratelimit.New(ratelimit.Config{
+		RPS:       rule.RPS,
+		Burst:     rule.Burst,
+		CacheSize: rule.CacheSize,
+		CacheTTL:  time.Duration(rule.CacheTTL) * time.Second,
+	})
+
+	return ratelimit.NewMiddleware(route, limiter, keyFunc, ctrl.isAdminRequest, ctrl.exportedMetrics)
+}
+
+// isAdminRequest reports whether the request was made by a user with the
+// Admin role, used to let admins bypass rate limiting.
+func (ctrl *Controller) isAdminRequest(r *http.Request) bool {
+	u, ok := api.UserFromContext(r.Context())
+	return ok && u.Role == model.AdminRole
+}
+
+// authenticatedUser returns the user associated with the request, as
+// populated by authMiddleware further up the chain.
+func (ctrl *Controller) authenticatedUser(r *http.Request) (model.User, bool) {
+	return api.UserFromContext(r.Context())
+}
+
+// userKeyFromRequest derives a rate-limit key from the authenticated user
+// or API key on the request, falling back to the source IP for
+// unauthenticated callers.
+func (ctrl *Controller) userKeyFromRequest(r *http.Request) string {
+	if u, ok := api.UserFromContext(r.Context()); ok {
+		return "user:" + u.Name
+	}
+	return "ip:" + ratelimit.SourceIPKeyFunc(false)(r)
+}
+
 func mustNewHLL() *hyperloglog.HyperLogLogPlus {
 	hll, err := hyperloglog.NewPlus(uint8(18))
 	if err != nil {
@@ -185,7 +286,13 @@ func (ctrl *Controller) serverMux() (http.Handler, error) {
 	//  - Auth middleware should never redirect - the logic should be moved to the client side.
 	r := mux.NewRouter()
 
-	r.Use(contentencoding.Decode())
+	r.Use(compression.DecodeRequest())
+	// Registered on the router (rather than wrapped around it in
+	// getHandler) so that by the time it runs, gorilla/mux has already
+	// matched the route and mux.CurrentRoute is populated - letting spans
+	// and the span_duration_seconds histogram be named after the route
+	// template instead of the raw, attacker-controlled request path.
+	r.Use(ctrl.tracingMdw.Wrap)
 
 	ctrl.jwtTokenService = service.NewJWTTokenService(
 		[]byte(ctrl.config.Auth.JWTSecret),
@@ -231,8 +338,16 @@ func (ctrl *Controller) serverMux() (http.Handler, error) {
 			appsRouter.Methods(http.MethodDelete).Handler(h)
 		}
 	} else {
-		appsRouter.Methods(http.MethodGet).Handler(ctrl.getAppsHandler())
-		appsRouter.Methods(http.MethodDelete).Handler(authorizer.RequireAdminRole(ctrl.deleteAppsHandler()))
+		appsRouter.Methods(http.MethodGet).Handler(ctrl.requireOAuthScope("read", appNameFromQuery)(ctrl.getAppsHandler()))
+		appsRouter.Methods(http.MethodDelete).Handler(ctrl.requireOAuthScope("write", appNameFromQuery)(authorizer.RequireAdminRole(ctrl.deleteAppsHandler())))
+	}
+
+	if err := ctrl.registerOAuthServerRoutes(r); err != nil {
+		return nil, err
+	}
+	if ctrl.config.OAuthServer.Enabled {
+		oauthClientsRouter := apiRouter.PathPrefix("/oauth-clients").Subrouter()
+		oauthClientsRouter.Methods(http.MethodPost).Handler(authorizer.RequireAdminRole(http.HandlerFunc(ctrl.createOAuthClientHandler)))
 	}
 
 	ingestRouter := r.Path("/ingest").Subrouter()
@@ -245,6 +360,13 @@ func (ctrl *Controller) serverMux() (http.Handler, error) {
 				authz.Role(model.AgentRole),
 			))
 	}
+	ingestRouter.Use(ctrl.requireOAuthScope("write", appNameFromQuery))
+	// The rate limiter must run after auth so ctrl.isAdminRequest (the
+	// Admin bypass) can see the authenticated user, same as on the query
+	// path below.
+	if ctrl.ingestionRateLimiter != nil {
+		ingestRouter.Use(ctrl.ingestionRateLimiter.Wrap)
+	}
 
 	ingestRouter.Methods(http.MethodPost).Handler(ctrl.ingestHandler())
 
@@ -313,9 +435,15 @@ func (ctrl *Controller) serverMux() (http.Handler, error) {
 	}
 
 	// For these routes server responds with 401.
-	ctrl.addRoutes(r, routes,
+	queryMiddlewares := []mux.MiddlewareFunc{
 		ctrl.drainMiddleware,
-		ctrl.authMiddleware(nil))
+		ctrl.authMiddleware(nil),
+		ctrl.requireOAuthScope("read", appNameFromSelectorQuery),
+	}
+	if ctrl.queryRateLimiter != nil {
+		queryMiddlewares = append(queryMiddlewares, ctrl.queryRateLimiter.Wrap)
+	}
+	ctrl.addRoutes(r, routes, queryMiddlewares...)
 
 	// TODO(kolesnikovae):
 	//  Refactor: move mux part to pkg/api/router.
@@ -439,6 +567,19 @@ func (ctrl *Controller) getAuthRoutes() ([]route, error) {
 		}...)
 	}
 
+	if ctrl.config.Auth.OIDC.Enabled {
+		oidcHandler, err := newOIDCHandler(ctrl.config.Auth.OIDC, ctrl.config.BaseURL, ctrl.log)
+		if err != nil {
+			return nil, err
+		}
+
+		authRoutes = append(authRoutes, []route{
+			{"/auth/oidc/login", ctrl.oidcLoginHandler(oidcHandler)},
+			{"/auth/oidc/callback", ctrl.oidcCallbackHandler(oidcHandler)},
+			{"/auth/oidc/redirect", ctrl.oidcRedirectHandler(oidcHandler)},
+		}...)
+	}
+
 	return authRoutes, nil
 }
 
@@ -448,13 +589,18 @@ func (ctrl *Controller) getHandler() (http.Handler, error) {
 		return nil, err
 	}
 
-	gzhttpMiddleware, err := gzhttp.NewWrapper(gzhttp.MinSize(gzHTTPCompressionThreshold), gzhttp.CompressionLevel(gzip.BestSpeed))
-	if err != nil {
-		return nil, err
+	h := handler
+	if ctrl.config.Compression.Enabled {
+		compressionMiddleware, err := compression.NewMiddleware(ctrl.config.Compression, ctrl.exportedMetrics)
+		if err != nil {
+			return nil, err
+		}
+		h = compressionMiddleware.Wrap(h)
 	}
 
-	h := ctrl.corsMiddleware()(gzhttpMiddleware(handler))
+	h = ctrl.corsMiddleware()(h)
 	h = ctrl.logginMiddleware(h)
+	h = correlation.Middleware(h)
 
 	return h, nil
 }
@@ -480,9 +626,25 @@ func (ctrl *Controller) Start() error {
 
 	updates.StartVersionUpdateLoop()
 
-	if ctrl.config.TLSCertificateFile != "" && ctrl.config.TLSKeyFile != "" {
+	switch {
+	case ctrl.config.TLS.ACME.Enabled:
+		acmeManager, acmeErr := acme.NewManager(ctrl.config.TLS.ACME, ctrl.log, ctrl.exportedMetrics)
+		if acmeErr != nil {
+			return fmt.Errorf("initializing ACME: %w", acmeErr)
+		}
+		ctrl.httpServer.TLSConfig = acmeManager.TLSConfig()
+		if addr := ctrl.config.TLS.ACME.HTTPChallengeAddr; addr != "" {
+			ctrl.acmeHTTPServer = &http.Server{Addr: addr, Handler: acmeManager.HTTPHandler()}
+			go func() {
+				if httpErr := ctrl.acmeHTTPServer.ListenAndServe(); httpErr != nil && !errors.Is(httpErr, http.ErrServerClosed) {
+					logrus.WithError(httpErr).Error("ACME HTTP-01 challenge listener stopped")
+				}
+			}()
+		}
+		err = ctrl.httpServer.ListenAndServeTLS("", "")
+	case ctrl.config.TLSCertificateFile != "" && ctrl.config.TLSKeyFile != "":
 		err = ctrl.httpServer.ListenAndServeTLS(ctrl.config.TLSCertificateFile, ctrl.config.TLSKeyFile)
-	} else {
+	default:
 		err = ctrl.httpServer.ListenAndServe()
 	}
 
@@ -497,6 +659,18 @@ func (ctrl *Controller) Start() error {
 func (ctrl *Controller) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
+	if ctrl.tracingShutdown != nil {
+		if err := ctrl.tracingShutdown(ctx); err != nil {
+			logrus.WithError(err).Warn("failed to shut down tracer provider")
+		}
+	}
+	if ctrl.acmeHTTPServer != nil {
+		if err := ctrl.acmeHTTPServer.Shutdown(ctx); err != nil {
+			logrus.WithError(err).Warn("failed to shut down ACME HTTP-01 challenge listener")
+		}
+	}
+	ctrl.ingestionRateLimiter.Close()
+	ctrl.queryRateLimiter.Close()
 	return ctrl.httpServer.Shutdown(ctx)
 }
 
@@ -590,11 +764,51 @@ func expectFormats(format string) error {
 }
 
 func (ctrl *Controller) logginMiddleware(next http.Handler) http.Handler {
-	if ctrl.config.LogLevel == "debug" {
-		// log to Stdout using Apache Common Log Format
-		// TODO maybe use JSON?
-		return handlers.LoggingHandler(os.Stdout, next)
+	format := ctrl.config.AccessLog.Format
+	if format == "" {
+		if ctrl.config.LogLevel != "debug" {
+			return next
+		}
+		// Preserve the historical debug-mode default.
+		format = "common"
 	}
 
-	return next
+	if format == "json" {
+		return correlation.AccessLogHandler(config.AccessLog{Format: format}, ctrl.log, nil, ctrl.userNameFromRequest, next)
+	}
+
+	// The Apache-style formats write straight to Stdout; stitch in the
+	// request's trace ID so log lines can still be correlated with their
+	// trace outside JSON mode.
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out := io.Writer(os.Stdout)
+		if traceID := tracing.TraceID(r); traceID != "" {
+			out = &tracePrefixedWriter{w: out, prefix: "traceID=" + traceID + " "}
+		}
+		correlation.AccessLogHandler(config.AccessLog{Format: format}, ctrl.log, out, ctrl.userNameFromRequest, next).ServeHTTP(w, r)
+	})
+}
+
+// userNameFromRequest returns the authenticated user's name for access
+// log purposes, or "" when the request is unauthenticated.
+func (ctrl *Controller) userNameFromRequest(r *http.Request) string {
+	u, ok := api.UserFromContext(r.Context())
+	if !ok {
+		return ""
+	}
+	return u.Name
+}
+
+// tracePrefixedWriter prepends prefix to every write, used to stitch the
+// debug-mode Apache log line to the trace it was generated in.
+type tracePrefixedWriter struct {
+	w      io.Writer
+	prefix string
+}
+
+func (t *tracePrefixedWriter) Write(p []byte) (int, error) {
+	if _, err := t.w.Write([]byte(t.prefix)); err != nil {
+		return 0, err
+	}
+	return t.w.Write(p)
 }
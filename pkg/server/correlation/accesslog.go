@@ -0,0 +1,121 @@
+package correlation
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+// statusResponseWriter captures the status code and bytes written so they
+// can be logged after the handler has run.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
+
+// AccessLogHandler wraps next with an access log controlled by
+// cfg.Format: "common"/"combined" log an Apache-style line to out
+// (log.Writer() when out is nil); "json" emits one structured logrus
+// entry per request via log instead. Every format carries the same
+// method, path, status, duration, bytes, user, remote addr and
+// correlation ID - "common"/"combined" append the correlation ID, user and
+// duration rather than delegating to a plain gorilla/handlers logger that
+// can't carry them.
+func AccessLogHandler(cfg config.AccessLog, log *logrus.Logger, out io.Writer, userOf func(*http.Request) string, next http.Handler) http.Handler {
+	if cfg.Format == "json" {
+		return jsonAccessLogHandler(log, userOf, next)
+	}
+	if out == nil {
+		out = log.Writer()
+	}
+	return plainAccessLogHandler(cfg.Format == "combined", out, userOf, next)
+}
+
+// plainAccessLogHandler logs each request in an Apache common/combined
+// log format line, with a trailing correlation_id=.../user=.../
+// duration_ms=... suffix so
+// that, regardless of format, the same correlation information as the
+// "json" format carries through to operators grepping these logs.
+func plainAccessLogHandler(combined bool, out io.Writer, userOf func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+
+		user := "-"
+		if userOf != nil {
+			if u := userOf(r); u != "" {
+				user = u
+			}
+		}
+		id := FromRequest(r)
+		if id == "" {
+			id = "-"
+		}
+
+		line := fmt.Sprintf("%s - - [%s] %q %d %d",
+			remoteHost(r), start.Format("02/Jan/2006:15:04:05 -0700"),
+			fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto),
+			sw.status, sw.bytes,
+		)
+		if combined {
+			line += fmt.Sprintf(" %q %q", r.Referer(), r.UserAgent())
+		}
+		line += fmt.Sprintf(" correlation_id=%s user=%s duration_ms=%d\n", id, user, time.Since(start).Milliseconds())
+		_, _ = io.WriteString(out, line)
+	})
+}
+
+// remoteHost strips the port off r.RemoteAddr, falling back to the raw
+// value if it isn't a host:port pair.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func jsonAccessLogHandler(log *logrus.Logger, userOf func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+
+		entry := Logger(log, r).WithFields(logrus.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      sw.status,
+			"duration_ms": time.Since(start).Milliseconds(),
+			"bytes":       sw.bytes,
+			"remote_addr": r.RemoteAddr,
+		})
+		if userOf != nil {
+			if user := userOf(r); user != "" {
+				entry = entry.WithField("user", user)
+			}
+		}
+		entry.Info("http request")
+	})
+}
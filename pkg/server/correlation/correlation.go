@@ -0,0 +1,90 @@
+// Package correlation assigns a correlation ID to every inbound request
+// (reusing one supplied by the caller when present), makes it available
+// to request-scoped logging, and provides a RoundTripper that propagates
+// it to outbound calls made on that request's context. In the server it
+// is wired into the OIDC code exchange only; scrape fetches and
+// remote-read fan-out do not carry the ID.
+package correlation
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// HeaderNames are the headers Pyroscope reads an inbound correlation ID
+// from, in order of preference. The ID is always echoed back on
+// X-Request-ID.
+var HeaderNames = []string{"X-Request-ID", "X-Correlation-ID"}
+
+type contextKey struct{}
+
+// Middleware reads the correlation ID off the request, or generates one,
+// stores it on the request context, and echoes it back on the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := fromHeader(r)
+		if id == "" {
+			id = ulid.Make().String()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), contextKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func fromHeader(r *http.Request) string {
+	for _, h := range HeaderNames {
+		if v := r.Header.Get(h); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// FromContext returns the correlation ID stored in ctx, or "" if none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromRequest returns the correlation ID carried by r's context.
+func FromRequest(r *http.Request) string {
+	return FromContext(r.Context())
+}
+
+// Logger returns a logrus entry pre-populated with the request's
+// correlation ID, so every log line emitted while handling it can be
+// traced back to the request without threading the ID through manually.
+func Logger(log *logrus.Logger, r *http.Request) *logrus.Entry {
+	entry := log.WithField("correlation_id", FromRequest(r))
+	return entry
+}
+
+// SetRequestID sets the outbound correlation header on req from ctx, for
+// HTTP clients making calls on behalf of an inbound request (e.g. the
+// OIDC code exchange) so a single ID stitches the whole chain together.
+func SetRequestID(ctx context.Context, req *http.Request) {
+	if id := FromContext(ctx); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+}
+
+// RoundTripper wraps an http.RoundTripper to stamp the outbound
+// correlation header on every request it sends, keyed off the context
+// carried by the request being round-tripped.
+type RoundTripper struct {
+	Next http.RoundTripper
+}
+
+func (rt RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	SetRequestID(req.Context(), req)
+	next := rt.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
+}
@@ -0,0 +1,25 @@
+package server
+
+import "errors"
+
+// Errors surfaced by the OIDC login handler and Pyroscope's own OAuth2
+// authorization server endpoints.
+//
+// errInvalidOauthState, errNoCode and errPermissionDenied are referenced
+// by oauth_oidc.go, which landed a few commits before this file did -
+// those commits don't build in isolation. Left as-is rather than
+// rewriting already-committed history; new dependencies introduced by a
+// commit belong in that same commit going forward.
+var (
+	errInvalidOauthState = errors.New("invalid oauth state")
+	errNoCode            = errors.New("no code in callback request")
+	errPermissionDenied  = errors.New("permission denied")
+
+	errUnknownOAuthClient      = errors.New("unknown oauth client")
+	errInvalidRedirectURI      = errors.New("redirect_uri does not match a registered redirect URI")
+	errUnsupportedResponseType = errors.New("unsupported response_type")
+	errUnsupportedGrantType    = errors.New("unsupported grant_type")
+	errInvalidGrant            = errors.New("invalid or expired grant")
+	errInvalidClient           = errors.New("invalid client credentials")
+	errInsufficientScope       = errors.New("access token does not carry a sufficient scope")
+)
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "example-code-verifier"
+	sum := sha256.Sum256([]byte(verifier))
+	challengeS256 := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	cases := []struct {
+		name      string
+		challenge string
+		method    string
+		verifier  string
+		want      bool
+	}{
+		{"S256 match", challengeS256, "S256", verifier, true},
+		{"S256 mismatch", challengeS256, "S256", "wrong-verifier", false},
+		{"plain match", verifier, "plain", verifier, true},
+		{"plain mismatch", verifier, "plain", "wrong-verifier", false},
+		{"no method defaults to plain", verifier, "", verifier, true},
+		{"unsupported method rejected", verifier, "bogus", verifier, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := verifyPKCE(c.challenge, c.method, c.verifier); got != c.want {
+				t.Errorf("verifyPKCE(%q, %q, %q) = %v, want %v", c.challenge, c.method, c.verifier, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/pyroscope-io/pyroscope/pkg/model"
+	"github.com/pyroscope-io/pyroscope/pkg/service"
+)
+
+// registerOAuthServerRoutes wires Pyroscope's own OAuth2/OIDC
+// authorization server - used by third-party tools such as the Grafana
+// plugin, the CLI and IDE integrations to obtain scoped access tokens
+// instead of relying on long-lived API keys.
+func (ctrl *Controller) registerOAuthServerRoutes(r *mux.Router) error {
+	if !ctrl.config.OAuthServer.Enabled {
+		return nil
+	}
+
+	tokenService, err := service.NewOAuthTokenService(
+		time.Duration(ctrl.config.OAuthServer.AccessTokenTTLMinutes)*time.Minute,
+		ctrl.config.OAuthServer.SigningKeyFile,
+	)
+	if err != nil {
+		return err
+	}
+	ctrl.oauthTokenService = tokenService
+	ctrl.oauthClientService = service.NewOAuthClientService(ctrl.db)
+
+	// /oauth/authorize is where the end user's browser session is
+	// consulted to grant (or deny) the client's request, so it needs the
+	// same session auth as the other protected pages.
+	authorizeRouter := r.NewRoute().Subrouter()
+	authorizeRouter.Use(ctrl.drainMiddleware, ctrl.authMiddleware(nil))
+	authorizeRouter.Path("/oauth/authorize").Methods(http.MethodGet, http.MethodPost).HandlerFunc(ctrl.oauthAuthorizeHandler)
+
+	// The remaining endpoints are called by the OAuth2 client itself, not
+	// by the end user's browser, and authenticate via their own
+	// credentials (client secret, refresh token, ...), so they sit behind
+	// drainMiddleware only, like the rest of the unauthenticated routes.
+	oauthRouter := r.NewRoute().Subrouter()
+	oauthRouter.Use(ctrl.drainMiddleware)
+	oauthRouter.Path("/oauth/token").Methods(http.MethodPost).HandlerFunc(ctrl.oauthTokenHandler)
+	oauthRouter.Path("/oauth/introspect").Methods(http.MethodPost).HandlerFunc(ctrl.oauthIntrospectHandler)
+	oauthRouter.Path("/oauth/revoke").Methods(http.MethodPost).HandlerFunc(ctrl.oauthRevokeHandler)
+	oauthRouter.Path("/.well-known/openid-configuration").Methods(http.MethodGet).HandlerFunc(ctrl.oidcDiscoveryHandler)
+	oauthRouter.Path("/.well-known/jwks.json").Methods(http.MethodGet).HandlerFunc(ctrl.oauthJWKSHandler)
+	return nil
+}
+
+// oauthAuthorizeHandler implements the authorization endpoint of the
+// authorization code + PKCE flow. On GET it expects the end user to
+// already be authenticated (the route sits behind authMiddleware); it
+// mints a short-lived code and redirects back to the client's
+// redirect_uri.
+func (ctrl *Controller) oauthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	clientID := q.Get("client_id")
+	redirectURI := q.Get("redirect_uri")
+	codeChallenge := q.Get("code_challenge")
+	codeChallengeMethod := q.Get("code_challenge_method")
+
+	client, err := ctrl.oauthClientService.FindClient(r.Context(), clientID)
+	if err != nil {
+		ctrl.httpUtils.HandleError(r, w, errUnknownOAuthClient)
+		return
+	}
+	if !service.RedirectURIAllowed(client, redirectURI) {
+		ctrl.httpUtils.HandleError(r, w, errInvalidRedirectURI)
+		return
+	}
+	if q.Get("response_type") != "code" {
+		ctrl.httpUtils.HandleError(r, w, errUnsupportedResponseType)
+		return
+	}
+
+	u, ok := ctrl.authenticatedUser(r)
+	if !ok {
+		ctrl.redirectPreservingBaseURL(w, r, "/login", http.StatusTemporaryRedirect)
+		return
+	}
+
+	code, err := randomState()
+	if err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+	grant := model.OAuthAuthorizationCode{
+		Code:                code,
+		ClientID:            clientID,
+		UserID:              u.ID,
+		RedirectURI:         redirectURI,
+		Scopes:              model.StringArray(toStrings(model.ParseOAuthScopes(q.Get("scope")))),
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: codeChallengeMethod,
+		ExpiresAt:           time.Now().Add(2 * time.Minute),
+	}
+	if err = ctrl.db.WithContext(r.Context()).Create(&grant).Error; err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+
+	redirectTo, err := url.Parse(redirectURI)
+	if err != nil {
+		ctrl.httpUtils.HandleError(r, w, errInvalidRedirectURI)
+		return
+	}
+	query := redirectTo.Query()
+	query.Set("code", code)
+	if state := q.Get("state"); state != "" {
+		query.Set("state", state)
+	}
+	redirectTo.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectTo.String(), http.StatusFound)
+}
+
+// oauthTokenHandler implements the token endpoint, supporting the
+// authorization_code (+ PKCE), client_credentials and refresh_token
+// grants.
+func (ctrl *Controller) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		ctrl.oauthExchangeAuthorizationCode(w, r)
+	case "client_credentials":
+		ctrl.oauthClientCredentials(w, r)
+	case "refresh_token":
+		ctrl.oauthRefreshToken(w, r)
+	default:
+		ctrl.httpUtils.HandleError(r, w, errUnsupportedGrantType)
+	}
+}
+
+func (ctrl *Controller) oauthExchangeAuthorizationCode(w http.ResponseWriter, r *http.Request) {
+	code := r.PostForm.Get("code")
+	verifier := r.PostForm.Get("code_verifier")
+	clientID := r.PostForm.Get("client_id")
+	redirectURI := r.PostForm.Get("redirect_uri")
+
+	var grant model.OAuthAuthorizationCode
+	if err := ctrl.db.WithContext(r.Context()).Where("code = ?", code).First(&grant).Error; err != nil {
+		ctrl.httpUtils.HandleError(r, w, errInvalidGrant)
+		return
+	}
+	// Authorization codes are single-use.
+	ctrl.db.WithContext(r.Context()).Delete(&grant)
+
+	if grant.ClientID != clientID || time.Now().After(grant.ExpiresAt) {
+		ctrl.httpUtils.HandleError(r, w, errInvalidGrant)
+		return
+	}
+	// RFC 6749 §4.1.3: redirect_uri must be present and identical to the
+	// one bound to the code if one was supplied to /oauth/authorize, so a
+	// stolen code can't be redeemed against a different redirect target.
+	if grant.RedirectURI != "" && redirectURI != grant.RedirectURI {
+		ctrl.httpUtils.HandleError(r, w, errInvalidGrant)
+		return
+	}
+	if grant.CodeChallenge != "" && !verifyPKCE(grant.CodeChallenge, grant.CodeChallengeMethod, verifier) {
+		ctrl.httpUtils.HandleError(r, w, errInvalidGrant)
+		return
+	}
+
+	ctrl.issueAccessToken(w, r, clientID, grant.UserID, grant.Scopes, true)
+}
+
+func (ctrl *Controller) oauthClientCredentials(w http.ResponseWriter, r *http.Request) {
+	clientID := r.PostForm.Get("client_id")
+	clientSecret := r.PostForm.Get("client_secret")
+
+	client, err := ctrl.oauthClientService.Authenticate(r.Context(), clientID, clientSecret)
+	if err != nil {
+		ctrl.httpUtils.HandleError(r, w, errInvalidClient)
+		return
+	}
+	ctrl.issueAccessToken(w, r, client.ClientID, 0, client.AllowedScopes, false)
+}
+
+func (ctrl *Controller) oauthRefreshToken(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.PostForm.Get("refresh_token")
+
+	var rt model.OAuthRefreshToken
+	if err := ctrl.db.WithContext(r.Context()).Where("token = ? AND revoked = ?", refreshToken, false).First(&rt).Error; err != nil {
+		ctrl.httpUtils.HandleError(r, w, errInvalidGrant)
+		return
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		ctrl.httpUtils.HandleError(r, w, errInvalidGrant)
+		return
+	}
+	// Rotate: the presented refresh token is revoked once it is redeemed.
+	if err := ctrl.db.WithContext(r.Context()).Model(&rt).Update("revoked", true).Error; err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+	ctrl.issueAccessToken(w, r, rt.ClientID, rt.UserID, rt.Scopes, true)
+}
+
+// issueAccessToken signs a new access token and, for interactive grants,
+// a rotating refresh token, and writes the standard OAuth2 JSON response.
+func (ctrl *Controller) issueAccessToken(w http.ResponseWriter, r *http.Request, clientID string, userID uint, scopes []string, withRefreshToken bool) {
+	subject := "0"
+	if userID != 0 {
+		subject = uintToString(userID)
+	}
+	accessToken, err := ctrl.oauthTokenService.Sign(subject, clientID, scopes)
+	if err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(ctrl.oauthTokenService.TTL().Seconds()),
+		"scope":        joinScopes(scopes),
+	}
+
+	if withRefreshToken {
+		refreshToken, err := randomState()
+		if err != nil {
+			ctrl.httpUtils.HandleError(r, w, err)
+			return
+		}
+		rt := model.OAuthRefreshToken{
+			Token:     refreshToken,
+			ClientID:  clientID,
+			UserID:    userID,
+			Scopes:    scopes,
+			ExpiresAt: time.Now().Add(30 * 24 * time.Hour),
+		}
+		if err = ctrl.db.WithContext(r.Context()).Create(&rt).Error; err != nil {
+			ctrl.httpUtils.HandleError(r, w, err)
+			return
+		}
+		resp["refresh_token"] = refreshToken
+	}
+
+	ctrl.httpUtils.WriteResponseJSON(r, w, resp)
+}
+
+func (ctrl *Controller) oauthIntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+	claims, err := ctrl.oauthTokenService.Verify(r.PostForm.Get("token"))
+	if err != nil {
+		ctrl.httpUtils.WriteResponseJSON(r, w, map[string]interface{}{"active": false})
+		return
+	}
+	ctrl.httpUtils.WriteResponseJSON(r, w, map[string]interface{}{
+		"active":    true,
+		"client_id": claims.ClientID,
+		"sub":       claims.Subject,
+		"scope":     joinScopes(claims.Scopes),
+		"exp":       claims.ExpiresAt.Unix(),
+	})
+}
+
+func (ctrl *Controller) oauthRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+	ctrl.db.WithContext(r.Context()).
+		Model(&model.OAuthRefreshToken{}).
+		Where("token = ?", r.PostForm.Get("token")).
+		Update("revoked", true)
+	w.WriteHeader(http.StatusOK)
+}
+
+// createOAuthClientRequest is the body expected by createOAuthClientHandler.
+type createOAuthClientRequest struct {
+	Name          string   `json:"name"`
+	RedirectURIs  []string `json:"redirect_uris"`
+	AllowedScopes []string `json:"allowed_scopes"`
+}
+
+// createOAuthClientHandler registers a new OAuth2 client - e.g. the
+// Grafana plugin, the CLI or an IDE integration - and is the only way to
+// obtain a client_id/client_secret pair. It sits behind the Admin-only
+// /api/oauth-clients route.
+func (ctrl *Controller) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var req createOAuthClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+
+	client, secret, err := ctrl.oauthClientService.CreateClient(r.Context(), service.CreateOAuthClientParams{
+		Name:          req.Name,
+		RedirectURIs:  req.RedirectURIs,
+		AllowedScopes: req.AllowedScopes,
+	})
+	if err != nil {
+		ctrl.httpUtils.HandleError(r, w, err)
+		return
+	}
+
+	ctrl.httpUtils.WriteResponseJSON(r, w, map[string]interface{}{
+		"client_id":     client.ClientID,
+		"client_secret": secret,
+		"name":          client.Name,
+	})
+}
+
+func (ctrl *Controller) oidcDiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	base := ctrl.config.BaseURL
+	ctrl.httpUtils.WriteResponseJSON(r, w, map[string]interface{}{
+		"issuer":                                base,
+		"authorization_endpoint":                base + "/oauth/authorize",
+		"token_endpoint":                        base + "/oauth/token",
+		"introspection_endpoint":                base + "/oauth/introspect",
+		"revocation_endpoint":                   base + "/oauth/revoke",
+		"jwks_uri":                              base + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "client_credentials", "refresh_token"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (ctrl *Controller) oauthJWKSHandler(w http.ResponseWriter, r *http.Request) {
+	ctrl.httpUtils.WriteResponseJSON(r, w, ctrl.oauthTokenService.JWKS())
+}
+
+func verifyPKCE(challenge, method, verifier string) bool {
+	switch method {
+	case "", "plain":
+		return challenge == verifier
+	case "S256":
+		sum := sha256.Sum256([]byte(verifier))
+		return challenge == base64.RawURLEncoding.EncodeToString(sum[:])
+	default:
+		return false
+	}
+}
+
+func toStrings(scopes []model.OAuthScope) []string {
+	out := make([]string, len(scopes))
+	for i, s := range scopes {
+		out[i] = string(s)
+	}
+	return out
+}
+
+func joinScopes(scopes []string) string {
+	out := ""
+	for i, s := range scopes {
+		if i > 0 {
+			out += " "
+		}
+		out += s
+	}
+	return out
+}
+
+func uintToString(v uint) string {
+	if v == 0 {
+		return "0"
+	}
+	digits := [20]byte{}
+	i := len(digits)
+	for v > 0 {
+		i--
+		digits[i] = byte('0' + v%10)
+		v /= 10
+	}
+	return string(digits[i:])
+}
@@ -0,0 +1,55 @@
+// Package ratelimit implements a per-key token-bucket rate limiter used to
+// throttle the ingestion and query HTTP endpoints.
+package ratelimit
+
+import "time"
+
+// bucket is a single token-bucket. It is not safe for concurrent use on its
+// own; callers must hold the owning keyedLimiter's lock.
+type bucket struct {
+	rps   float64
+	burst float64
+
+	tokens   float64
+	lastSeen time.Time
+}
+
+func newBucket(rps float64, burst int, now time.Time) *bucket {
+	return &bucket{
+		rps:      rps,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		lastSeen: now,
+	}
+}
+
+// allow reports whether a request arriving at now should be let through,
+// and refills the bucket based on the elapsed time since it was last used.
+func (b *bucket) allow(now time.Time) bool {
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.lastSeen = now
+	if elapsed > 0 {
+		b.tokens += elapsed * b.rps
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryAfter estimates how long the caller should wait before the next
+// token becomes available.
+func (b *bucket) retryAfter() time.Duration {
+	if b.rps <= 0 {
+		return time.Second
+	}
+	missing := 1 - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing/b.rps*1000) * time.Millisecond
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketAllowConsumesAndRefills(t *testing.T) {
+	now := time.Now()
+	b := newBucket(1, 2, now)
+
+	if !b.allow(now) {
+		t.Fatal("expected first request to be allowed (burst)")
+	}
+	if !b.allow(now) {
+		t.Fatal("expected second request to be allowed (burst)")
+	}
+	if b.allow(now) {
+		t.Fatal("expected third request to be rejected, burst exhausted")
+	}
+
+	// rps=1, so after 1s exactly one token should have refilled.
+	now = now.Add(time.Second)
+	if !b.allow(now) {
+		t.Fatal("expected request to be allowed after refill")
+	}
+	if b.allow(now) {
+		t.Fatal("expected no extra tokens beyond the single refill")
+	}
+}
+
+func TestBucketAllowCapsAtBurst(t *testing.T) {
+	now := time.Now()
+	b := newBucket(1, 2, now)
+
+	// A long idle period should not accumulate more than burst tokens.
+	now = now.Add(time.Hour)
+	if !b.allow(now) || !b.allow(now) {
+		t.Fatal("expected burst tokens to be available after a long idle period")
+	}
+	if b.allow(now) {
+		t.Fatal("expected tokens to be capped at burst, not accumulated indefinitely")
+	}
+}
+
+func TestBucketRetryAfter(t *testing.T) {
+	now := time.Now()
+	b := newBucket(2, 1, now)
+
+	if !b.allow(now) {
+		t.Fatal("expected first request to be allowed")
+	}
+	if b.allow(now) {
+		t.Fatal("expected second request to be rejected")
+	}
+	if d := b.retryAfter(); d <= 0 || d > 500*time.Millisecond {
+		t.Fatalf("expected retryAfter to be within ~1/rps seconds, got %v", d)
+	}
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Limiter rate-limits requests keyed by an arbitrary string (source IP,
+// API key, application name, ...). Each key gets its own token bucket;
+// entries that have been idle for longer than TTL are evicted by the
+// background sweeper, and the LRU cap prevents unbounded growth from
+// high-cardinality keys (e.g. source IPs) even before entries go idle.
+type Limiter struct {
+	rps   float64
+	burst int
+	size  int
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+
+	stop chan struct{}
+	once sync.Once
+}
+
+type entry struct {
+	key    string
+	bucket *bucket
+}
+
+// Config describes the limit applied by a Limiter and the bookkeeping
+// bounds for its key cache.
+type Config struct {
+	RPS   float64
+	Burst int
+
+	// CacheSize is the maximum number of distinct keys tracked at once.
+	CacheSize int
+	// CacheTTL is how long an idle key is kept before being swept.
+	CacheTTL time.Duration
+}
+
+// New creates a Limiter and starts its background TTL sweeper. Callers
+// must call Close when the limiter is no longer needed.
+func New(c Config) *Limiter {
+	if c.CacheSize <= 0 {
+		c.CacheSize = 10000
+	}
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = 10 * time.Minute
+	}
+	l := &Limiter{
+		rps:     c.RPS,
+		burst:   c.Burst,
+		size:    c.CacheSize,
+		ttl:     c.CacheTTL,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		stop:    make(chan struct{}),
+	}
+	go l.sweepLoop()
+	return l
+}
+
+// Allow reports whether a request for key should be let through, and if
+// not, how long the caller should wait before retrying.
+func (l *Limiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok2 := l.entries[key]
+	var e *entry
+	if ok2 {
+		e = el.Value.(*entry)
+		l.order.MoveToFront(el)
+	} else {
+		e = &entry{key: key, bucket: newBucket(l.rps, l.burst, now)}
+		l.entries[key] = l.order.PushFront(e)
+		l.evictOverflow()
+	}
+
+	if e.bucket.allow(now) {
+		return true, 0
+	}
+	return false, e.bucket.retryAfter()
+}
+
+// evictOverflow drops the least-recently-used entries once the cache
+// exceeds its configured size. Callers must hold l.mu.
+func (l *Limiter) evictOverflow() {
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		if oldest == nil {
+			return
+		}
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*entry).key)
+	}
+}
+
+func (l *Limiter) sweepLoop() {
+	interval := l.ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			l.sweep(time.Now())
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for el := l.order.Back(); el != nil; {
+		e := el.Value.(*entry)
+		prev := el.Prev()
+		if now.Sub(e.bucket.lastSeen) > l.ttl {
+			l.order.Remove(el)
+			delete(l.entries, e.key)
+		}
+		el = prev
+	}
+}
+
+// Close stops the background sweeper.
+func (l *Limiter) Close() {
+	l.once.Do(func() { close(l.stop) })
+}
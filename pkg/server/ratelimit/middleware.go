@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// KeyFunc extracts the rate-limit key for a request, e.g. the source IP,
+// the authenticated user/API key, or the application name derived from
+// the ingestion request. A zero-value (empty) key means the request
+// should not be limited (e.g. no app name could be parsed yet).
+type KeyFunc func(r *http.Request) string
+
+// BypassFunc reports whether the request should skip rate limiting
+// entirely, e.g. because it was made by an admin.
+type BypassFunc func(r *http.Request) bool
+
+// Middleware enforces a Limiter over HTTP requests and exposes a
+// Prometheus counter of rejections labeled by route and reject reason.
+type Middleware struct {
+	route   string
+	limiter *Limiter
+	keyFunc KeyFunc
+	bypass  BypassFunc
+	counter *prometheus.CounterVec
+}
+
+// NewMiddleware builds a rate-limit Middleware for the given route name.
+// reg may be nil, in which case the counter is not registered (useful in
+// tests); registration errors from an AlreadyRegistered counter being
+// shared across routes are ignored since the counter is keyed by route.
+func NewMiddleware(route string, limiter *Limiter, keyFunc KeyFunc, bypass BypassFunc, reg prometheus.Registerer) *Middleware {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pyroscope",
+		Subsystem: "ratelimit",
+		Name:      "rejected_total",
+		Help:      "Number of requests rejected by the rate limiter, by route and reason.",
+	}, []string{"route", "reason"})
+	if reg != nil {
+		if err := reg.Register(counter); err != nil {
+			if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+				counter = are.ExistingCollector.(*prometheus.CounterVec)
+			}
+		}
+	}
+	return &Middleware{
+		route:   route,
+		limiter: limiter,
+		keyFunc: keyFunc,
+		bypass:  bypass,
+		counter: counter,
+	}
+}
+
+// Wrap returns an http.Handler that enforces the rate limit before
+// delegating to next.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	if m == nil || m.limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.bypass != nil && m.bypass(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := m.keyFunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, retryAfter := m.limiter.Allow(key)
+		if !ok {
+			m.counter.WithLabelValues(m.route, "rate_limited").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+1)))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Close stops the underlying Limiter's sweep loop goroutine. It's safe to
+// call on a nil Middleware (the route's rate limiting is simply
+// disabled).
+func (m *Middleware) Close() {
+	if m == nil || m.limiter == nil {
+		return
+	}
+	m.limiter.Close()
+}
+
+// SourceIPKeyFunc returns a KeyFunc that keys on the request's source IP,
+// honoring X-Forwarded-For when trustForwardedFor is set (the left-most
+// address is used, as it is set by the first proxy in the chain).
+func SourceIPKeyFunc(trustForwardedFor bool) KeyFunc {
+	return func(r *http.Request) string {
+		if trustForwardedFor {
+			if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+				parts := strings.Split(xff, ",")
+				return strings.TrimSpace(parts[0])
+			}
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// UserKeyFunc returns a KeyFunc that keys on the authenticated user or API
+// key associated with the request, as returned by userFromRequest; when
+// userFromRequest returns the empty string, the request is not limited.
+func UserKeyFunc(userFromRequest func(r *http.Request) string) KeyFunc {
+	return func(r *http.Request) string {
+		return userFromRequest(r)
+	}
+}
+
+// AppNameKeyFunc returns a KeyFunc that keys on the application name
+// derived from an ingestion request's "name" query parameter, falling
+// back to the source IP - as SourceIPKeyFunc would derive it - when the
+// parameter is missing, so an attacker can't defeat the limiter by simply
+// omitting it.
+func AppNameKeyFunc(trustForwardedFor bool) KeyFunc {
+	ipKeyFunc := SourceIPKeyFunc(trustForwardedFor)
+	return func(r *http.Request) string {
+		if name := r.URL.Query().Get("name"); name != "" {
+			return "app:" + name
+		}
+		return "ip:" + ipKeyFunc(r)
+	}
+}
@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/pyroscope-io/pyroscope/pkg/server"
+
+// Middleware starts a server span per request, extracting the W3C
+// traceparent header from the incoming request so spans started by a
+// calling agent or the Grafana plugin are linked as the parent.
+type Middleware struct {
+	tracer    trace.Tracer
+	durations *prometheus.HistogramVec
+}
+
+// NewMiddleware builds a tracing Middleware. reg may be nil.
+func NewMiddleware(reg prometheus.Registerer) *Middleware {
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "pyroscope",
+		Subsystem: "tracing",
+		Name:      "span_duration_seconds",
+		Help:      "Duration of server spans, by span name.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"span_name"})
+	if reg != nil {
+		_ = reg.Register(durations)
+	}
+	return &Middleware{
+		tracer:    otel.Tracer(tracerName),
+		durations: durations,
+	}
+}
+
+// Wrap returns an http.Handler that starts a span named after the
+// matched route (or the raw path, before routing) around next, and
+// stamps it with route/user/app/time-range attributes.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		spanName := routeTemplate(r)
+		ctx, span := m.tracer.Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindServer))
+		span.SetAttributes(
+			attribute.String("http.route", spanName),
+			attribute.String("http.method", r.Method),
+		)
+		stampQueryAttributes(span, r)
+
+		start := time.Now()
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		m.durations.WithLabelValues(spanName).Observe(time.Since(start).Seconds())
+		span.End()
+	})
+}
+
+// stampQueryAttributes adds the app name and query time range to the span
+// when present, so a trace can be correlated back to what was queried.
+func stampQueryAttributes(span trace.Span, r *http.Request) {
+	q := r.URL.Query()
+	if name := q.Get("name"); name != "" {
+		span.SetAttributes(attribute.String("pyroscope.app_name", name))
+	}
+	if from := q.Get("from"); from != "" {
+		span.SetAttributes(attribute.String("pyroscope.query.from", from))
+	}
+	if until := q.Get("until"); until != "" {
+		span.SetAttributes(attribute.String("pyroscope.query.until", until))
+	}
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
+
+// SetUser stamps the user attribute on the span carried by the request's
+// context. Middleware.Wrap does not stamp the user itself, since at the
+// point it starts the span the request hasn't been authenticated yet, and
+// nothing in the server calls SetUser, so server spans carry no user
+// attribute.
+func SetUser(r *http.Request, userName string) {
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("pyroscope.user", userName))
+}
+
+// TraceID returns the hex-encoded trace ID of the span carried by r's
+// context, or the empty string when that context carries no span context
+// with a valid trace ID - used to
+// stitch together the debug-mode access log with its trace.
+func TraceID(r *http.Request) string {
+	sc := trace.SpanContextFromContext(r.Context())
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
@@ -0,0 +1,82 @@
+// Package tracing wires Pyroscope's HTTP server into OpenTelemetry: it
+// builds the configured TracerProvider and exposes a middleware that
+// starts a server span per request, the tracing analogue of the existing
+// Prometheus metricsMdw.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+// NewProvider builds a TracerProvider from c. When c.Enabled is false, or
+// c.Exporter is "none", the returned provider creates spans but never
+// exports them, so downstream code can unconditionally create spans
+// without checking whether tracing is enabled.
+func NewProvider(ctx context.Context, c config.Tracing, serviceName string) (*sdktrace.TracerProvider, error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(newSampler(c)),
+		sdktrace.WithResource(newResource(c, serviceName)),
+	}
+
+	if c.Enabled && c.Exporter != "" && c.Exporter != "none" {
+		exp, err := newExporter(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("initializing %s trace exporter: %w", c.Exporter, err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp, nil
+}
+
+func newExporter(ctx context.Context, c config.Tracing) (sdktrace.SpanExporter, error) {
+	switch c.Exporter {
+	case "otlp-grpc":
+		return otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(c.Endpoint), otlptracegrpc.WithInsecure())
+	case "otlp-http":
+		return otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(c.Endpoint), otlptracehttp.WithInsecure())
+	case "jaeger":
+		return jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(c.Endpoint)))
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", c.Exporter)
+	}
+}
+
+func newSampler(c config.Tracing) sdktrace.Sampler {
+	switch c.Sampler {
+	case "always":
+		return sdktrace.AlwaysSample()
+	case "never":
+		return sdktrace.NeverSample()
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(c.SamplerArg)
+	case "parent", "":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	default:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+}
+
+func newResource(c config.Tracing, serviceName string) *resource.Resource {
+	attrs := []attribute.KeyValue{semconv.ServiceNameKey.String(serviceName)}
+	for k, v := range c.ResourceAttributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	return resource.NewSchemaless(attrs...)
+}
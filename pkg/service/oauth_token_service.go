@@ -0,0 +1,163 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OAuthAccessTokenClaims are the claims carried by access tokens minted
+// for third-party OAuth2 clients. Unlike the session JWT minted by
+// jwtTokenService for first-party logins, these are signed with RS256 so
+// that resource servers (and Pyroscope itself, on incoming requests) can
+// verify them against the published JWKS without sharing a secret.
+type OAuthAccessTokenClaims struct {
+	jwt.RegisteredClaims
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scope"`
+}
+
+// OAuthTokenService signs and verifies OAuth2 access tokens with an
+// RS256 key pair, and publishes the public half as a JWKS document so
+// that clients of the /.well-known/jwks.json endpoint are meaningful.
+type OAuthTokenService struct {
+	key   *rsa.PrivateKey
+	keyID string
+	ttl   time.Duration
+}
+
+// defaultAccessTokenTTL is used when accessTokenTTL is unset (<= 0), so
+// that enabling OAuthServer without also setting AccessTokenTTLMinutes
+// doesn't mint tokens that are already expired.
+const defaultAccessTokenTTL = time.Hour
+
+// NewOAuthTokenService loads the RS256 signing key from signingKeyFile,
+// generating and persisting one there on first start, and returns a
+// ready-to-use OAuthTokenService. accessTokenTTL governs how long minted
+// access tokens remain valid; <= 0 falls back to defaultAccessTokenTTL.
+//
+// signingKeyFile must point at the same file/shared volume on every
+// replica of a multi-replica deployment: otherwise each replica would
+// mint and verify tokens under its own key, and a token issued by one
+// replica would be rejected by another. An empty signingKeyFile generates
+// an ephemeral, process-local key instead, which is only appropriate for
+// a single-replica/development setup, since every restart then
+// invalidates every token issued so far.
+func NewOAuthTokenService(accessTokenTTL time.Duration, signingKeyFile string) (*OAuthTokenService, error) {
+	if accessTokenTTL <= 0 {
+		accessTokenTTL = defaultAccessTokenTTL
+	}
+	key, err := loadOrGenerateSigningKey(signingKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading OAuth2 signing key: %w", err)
+	}
+	return &OAuthTokenService{
+		key:   key,
+		keyID: "pyroscope-oauth-1",
+		ttl:   accessTokenTTL,
+	}, nil
+}
+
+// loadOrGenerateSigningKey reads a PEM-encoded PKCS#1 RSA private key
+// from path, generating and persisting a new 2048-bit one there if it
+// doesn't exist yet. An empty path always generates a fresh, unpersisted
+// key.
+func loadOrGenerateSigningKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	if b, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("%s does not contain a PEM-encoded key", path)
+		}
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generating RS256 key pair: %w", err)
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// TTL returns the lifetime applied to newly minted access tokens.
+func (s *OAuthTokenService) TTL() time.Duration {
+	return s.ttl
+}
+
+// Sign mints a new RS256-signed access token for clientID carrying the
+// given scopes and subject (the Pyroscope user ID as a string).
+func (s *OAuthTokenService) Sign(subject, clientID string, scopes []string) (string, error) {
+	now := time.Now()
+	claims := OAuthAccessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.ttl)),
+		},
+		ClientID: clientID,
+		Scopes:   scopes,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	return token.SignedString(s.key)
+}
+
+// Verify parses and validates an access token, returning its claims.
+func (s *OAuthTokenService) Verify(raw string) (*OAuthAccessTokenClaims, error) {
+	var claims OAuthAccessTokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return &s.key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// JWK is the subset of RFC 7517 fields needed to publish an RSA public
+// key for verification by third parties.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the published form of the service's public signing key.
+func (s *OAuthTokenService) JWKS() JWKS {
+	return JWKS{Keys: []JWK{{
+		Kty: "RSA",
+		Use: "sig",
+		Kid: s.keyID,
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(s.key.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.PublicKey.E)).Bytes()),
+	}}}
+}